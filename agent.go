@@ -0,0 +1,79 @@
+// © 2025 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the ISC
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// sshAuthMethod builds the [ssh.AuthMethod] used to authenticate with the
+// environment: identities offered by ssh-agent when -use-agent is set or the
+// managed private key is missing, the managed private key otherwise.
+func (a *app) sshAuthMethod() (ssh.AuthMethod, error) {
+	if a.useAgent {
+		return agentAuthMethod()
+	}
+	if _, err := os.Stat(a.privateKeyPath); errors.Is(err, os.ErrNotExist) {
+		return agentAuthMethod()
+	}
+
+	key, err := os.ReadFile(a.privateKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	return ssh.PublicKeys(signer), nil
+}
+
+// dialAgent connects to the ssh-agent listening on SSH_AUTH_SOCK.
+func dialAgent() (agent.Agent, error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, errors.New("SSH_AUTH_SOCK is not set, cannot use ssh-agent")
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to ssh-agent: %w", err)
+	}
+	return agent.NewClient(conn), nil
+}
+
+// agentAuthMethod authenticates using every identity currently loaded into
+// ssh-agent.
+func agentAuthMethod() (ssh.AuthMethod, error) {
+	ag, err := dialAgent()
+	if err != nil {
+		return nil, err
+	}
+	return ssh.PublicKeysCallback(ag.Signers), nil
+}
+
+// agentPublicKeys returns the OpenSSH authorized_keys-format public keys for
+// every identity currently loaded into ssh-agent.
+func agentPublicKeys() ([]string, error) {
+	ag, err := dialAgent()
+	if err != nil {
+		return nil, err
+	}
+	keys, err := ag.List()
+	if err != nil {
+		return nil, fmt.Errorf("could not list ssh-agent identities: %w", err)
+	}
+	out := make([]string, len(keys))
+	for i, k := range keys {
+		out[i] = strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(k)), "\n")
+	}
+	return out, nil
+}
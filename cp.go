@@ -0,0 +1,159 @@
+// © 2025 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the ISC
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"go.astrophena.name/base/cli"
+
+	"github.com/pkg/sftp"
+)
+
+// remotePrefix denotes the remote side of a "cloudshell cp" invocation, in
+// the same spirit as scp's "host:" prefix.
+const remotePrefix = "cloudshell:"
+
+// cp implements the "cp" command: it copies a file or directory between the
+// local machine and the Cloud Shell environment over SFTP, with exactly one
+// of src or dst prefixed with "cloudshell:" to denote the remote side.
+func (a *app) cp(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("%w: expected exactly 2 arguments (src and dst)", cli.ErrInvalidArgs)
+	}
+	srcPath, srcRemote := strings.CutPrefix(args[0], remotePrefix)
+	dstPath, dstRemote := strings.CutPrefix(args[1], remotePrefix)
+	if srcRemote == dstRemote {
+		return fmt.Errorf("%w: exactly one of src or dst must have a %q prefix", cli.ErrInvalidArgs, remotePrefix)
+	}
+
+	if err := a.initClient(ctx); err != nil {
+		return err
+	}
+	if err := a.start(ctx); err != nil {
+		return err
+	}
+	e, err := a.getEnvironment(ctx)
+	if err != nil {
+		return err
+	}
+	client, err := a.dialSSH(e)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	sftpc, err := sftp.NewClient(client)
+	if err != nil {
+		return fmt.Errorf("failed to start sftp session: %w", err)
+	}
+	defer sftpc.Close()
+
+	if srcRemote {
+		return downloadPath(sftpc, srcPath, dstPath)
+	}
+	return uploadPath(sftpc, srcPath, dstPath)
+}
+
+// uploadPath copies localPath, recursing into directories, to remotePath on
+// the environment, preserving each file's mode and modification time.
+func uploadPath(c *sftp.Client, localPath, remotePath string) error {
+	info, err := os.Lstat(localPath)
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		if err := c.MkdirAll(remotePath); err != nil {
+			return err
+		}
+		entries, err := os.ReadDir(localPath)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := uploadPath(c, filepath.Join(localPath, entry.Name()), path.Join(remotePath, entry.Name())); err != nil {
+				return err
+			}
+		}
+		return c.Chmod(remotePath, info.Mode())
+	}
+
+	src, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := c.Create(remotePath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	if err := c.Chmod(remotePath, info.Mode()); err != nil {
+		return err
+	}
+	return c.Chtimes(remotePath, info.ModTime(), info.ModTime())
+}
+
+// downloadPath copies remotePath from the environment, recursing into
+// directories, to localPath, preserving each file's mode and modification
+// time.
+func downloadPath(c *sftp.Client, remotePath, localPath string) error {
+	info, err := c.Lstat(remotePath)
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		if err := os.MkdirAll(localPath, 0o755); err != nil {
+			return err
+		}
+		entries, err := c.ReadDir(remotePath)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := downloadPath(c, path.Join(remotePath, entry.Name()), filepath.Join(localPath, entry.Name())); err != nil {
+				return err
+			}
+		}
+		return os.Chmod(localPath, info.Mode())
+	}
+
+	src, err := c.Open(remotePath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(localPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(localPath, info.Mode()); err != nil {
+		return err
+	}
+	return os.Chtimes(localPath, info.ModTime(), info.ModTime())
+}
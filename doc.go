@@ -12,11 +12,58 @@ cloudshell gives access to the Google Cloud Shell from the terminal.
 Where <command> is one of the following:
 
   - info: Display the current status and details of the Cloud Shell environment,
-    including the Docker image and SSH connection information.
-  - ssh: Establish an SSH connection to the Cloud Shell environment. If the
-    environment is not running, it will be started automatically.
+    including the Docker image and SSH connection information. With
+    "-auth=gcloud", also shows the active gcloud account.
+  - ssh [-record] [-record-to path] [-- command...]: Establish an SSH
+    connection to the Cloud Shell environment. If the environment is not
+    running, it will be started automatically. With -record, the session is
+    recorded to a timestamped asciicast file (plus a ".typescript" sidecar)
+    under $XDG_STATE_HOME/cloudshell/sessions/; -record-to records to an
+    explicit path instead. With a trailing command, it's run non-interactively
+    instead of starting a shell, with stdin/stdout/stderr passed through and
+    the remote exit code propagated as cloudshell's own; -record/-record-to
+    aren't supported in that mode and are rejected.
+  - cp <src> <dst>: Copy a file or directory between the local machine and
+    the Cloud Shell environment over SFTP. Exactly one of src or dst must be
+    prefixed with "cloudshell:" to denote the remote side, e.g.
+    "cloudshell cp ./build cloudshell:~/build". File mode and modification
+    time are preserved; directories are copied recursively.
   - start: Start the Cloud Shell environment and wait until it is running.
-  - key <subcommand>: Manage additional public SSH keys for the environment.
+  - port-forward <localport:host:hostport>...: Tunnel one or more local TCP
+    ports through the Cloud Shell SSH connection. The environment is started
+    automatically if needed.
+  - forward [-L [bind:]port:host:hostport] [-R [bind:]port:host:hostport]
+    [-D [bind:]port]: OpenSSH-style port forwarding through the Cloud Shell
+    SSH connection. -L and -R forward a local or remote port to a host:port
+    reachable from the other side; -D starts a local SOCKS5 proxy. All three
+    flags may be repeated and combined in one invocation; the command blocks
+    until interrupted.
+  - web [-port 8080] [-local 127.0.0.1:8080]: Give one-shot access to a web
+    preview of a server listening on -port inside the environment. By
+    default opens the Cloud Shell web-preview URL in the browser; with
+    -local, forwards the given local address to the remote port over SSH
+    instead.
+  - proxy [-addr 127.0.0.1:2222]: Run a local SSH server that transparently
+    forwards every session, exec, subsystem (e.g. sftp) and port forward to
+    the Cloud Shell environment, so regular ssh/scp/rsync/Remote-SSH clients
+    can be pointed at a fixed local address. Incoming connections are
+    authenticated against $XDG_STATE_HOME/cloudshell/authorized_keys.
+  - ssh-config [-addr 127.0.0.1:2222] [-host cloudshell]: Print an OpenSSH
+    config block for ~/.ssh/config pointing at the proxy.
+  - key <subcommand>: Manage additional public SSH keys for the environment,
+    or the pinned Cloud Shell host keys.
+  - profile <subcommand>: Manage named profiles, so multiple Google accounts
+    or Cloud Shell environments can be juggled without clobbering each
+    other's state.
+
+Where profile <subcommand> is one of the following:
+
+  - profile list: Show every known profile, marking the active one with "*".
+  - profile use <name>: Switch the active profile, creating it if it doesn't
+    exist yet. Takes effect for every subsequent command until changed again.
+  - profile remove <name>: Delete a profile's state directory and forget it.
+    The implicit "default" profile can't be removed. Removing the active
+    profile switches back to "default".
 
 Where key <subcommand> is one of the following:
 
@@ -24,40 +71,93 @@ Where key <subcommand> is one of the following:
   - key add '<key>': Add a new public key. The key should be provided as a
     string, e.g., "$(cat ~/.ssh/id_rsa.pub)".
   - key remove '<key>': Remove a previously authorized public key.
+  - key rotate: Generate a fresh managed SSH key pair, authorize it with the
+    environment, wait for it to be picked up, then deauthorize and discard
+    the old one.
+  - key hostkey list: Show the fingerprints of all Cloud Shell host keys
+    pinned in $XDG_STATE_HOME/cloudshell/known_hosts.
+  - key hostkey forget <host>: Remove the pinned host key for host, so the
+    next connection trusts whatever key the server presents.
+
+# Host Key Verification
+
+cloudshell verifies the identity of the Cloud Shell SSH endpoint using a
+known_hosts file in the state directory, in the same trust-on-first-use
+fashion as OpenSSH: the first time a given host is seen, its key is pinned
+and its fingerprint is logged; every later connection to that host must
+present the same key, or cloudshell refuses to proceed. If the Cloud Shell
+host key legitimately changes, run "cloudshell key hostkey forget <host>"
+and reconnect.
+
+# Profiles
+
+By default, all of cloudshell's state (OAuth client and token, managed SSH
+key, known hosts, ...) lives directly in the state directory. Running
+"cloudshell profile use <name>" switches to a separate state directory,
+$XDG_STATE_HOME/cloudshell/<name>/, persisting the choice for every
+subsequent command; -profile <name> uses a given profile for a single
+command without switching it. This makes it practical to juggle multiple
+Google accounts, each with its own credentials, SSH key and Cloud Shell
+environment.
 
 # Setup
 
-To use cloudshell, you need to configure Google Cloud API access:
-
- 1. Create a project in the Google API Console.
- 2. Enable the Cloud Shell API for your project.
- 3. Create OAuth 2.0 credentials. Go to the "Credentials" page, click "Create
-    Credentials," and select "OAuth client ID." Choose "Desktop app" as the
-    application type.
- 4. Download the credentials as a JSON file and save it as client_secret.json.
- 5. Place this file in the application's state directory ($XDG_STATE_HOME/cloudshell/client_secret.json, typically ~/.local/state/cloudshell/client_secret.json)
+cloudshell supports three ways to authenticate with the Google Cloud Shell
+API, selected with the -auth flag (auto, oauth, adc or gcloud). "auto" is the
+default: it picks oauth if a client_secret.json is present, otherwise adc if
+Application Default Credentials are available, otherwise gcloud if it's on
+PATH.
+
+ 1. OAuth ("-auth=oauth"): create your own OAuth client.
+    a. Create a project in the Google API Console.
+    b. Enable the Cloud Shell API for your project.
+    c. Create OAuth 2.0 credentials. Go to the "Credentials" page, click
+    "Create Credentials," and select "OAuth client ID." Choose "Desktop
+    app" as the application type.
+    d. Download the credentials as a JSON file and save it as client_secret.json.
+    e. Place this file in the application's state directory ($XDG_STATE_HOME/cloudshell/client_secret.json, typically ~/.local/state/cloudshell/client_secret.json)
+ 2. Application Default Credentials ("-auth=adc"): no OAuth client needed.
+    Run "gcloud auth application-default login" once, or point
+    GOOGLE_APPLICATION_CREDENTIALS at a service account JSON file, or run on
+    GCE/Cloud Run where the metadata server provides credentials.
+ 3. gcloud ("-auth=gcloud"): reuses the credentials of an already
+    authenticated gcloud CLI (via "gcloud auth print-access-token"), with no
+    API Console setup and no separate ADC configuration required.
 
 # Authentication
 
-The first time you run any command, cloudshell will initiate an OAuth
-authentication flow. You will be prompted to open a URL in your browser, grant
-the application access to your Google account, and paste an authorization code
-back into the terminal.
+With "-auth=oauth", the first time you run any command, cloudshell will
+initiate an OAuth authentication flow: it starts a local callback server,
+opens the authorization URL in your browser (or prints it, if it can't),
+and waits for you to grant the application access to your Google account.
+The browser is then redirected back to the local server, which completes
+the flow automatically — no code to copy and paste.
 
 Upon successful authentication, an access token is saved to token.json in the
 state directory. This token will be used for all subsequent API requests.
 
+With "-auth=adc", cloudshell uses whatever Application Default Credentials are
+already available in the environment and does not manage any token file of
+its own.
+
 # SSH Key Management
 
-The first time cloudshell is run, it automatically generates a dedicated RSA
-SSH key pair for connecting to the Cloud Shell environment. This key is stored
-in the state directory.
+The first time cloudshell is run, it automatically generates a dedicated SSH
+key pair for connecting to the Cloud Shell environment. This key is stored in
+the state directory. Its type is controlled by -key-type (ed25519, rsa or
+ecdsa; ed25519 by default for new installs). Use "cloudshell key rotate" to
+replace it with a freshly generated key of the current -key-type without
+losing access.
 
 When you run cloudshell ssh or cloudshell start, the public key is
 automatically authorized with the environment.
 
 The key command can still be used to manage additional, user-provided public
 keys if you have advanced use cases that require them.
+
+With -use-agent, cloudshell authenticates SSH connections using ssh-agent
+(SSH_AUTH_SOCK) instead of the managed key, and cloudshell start authorizes
+every ssh-agent identity that isn't already in the environment's public keys.
 */
 package main
 
@@ -0,0 +1,289 @@
+// © 2025 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the ISC
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"go.astrophena.name/base/cli"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// forward implements the "forward" command: OpenSSH-style -L, -R and -D port
+// forwarding through the Cloud Shell SSH connection, all reusing the same
+// authenticated client.
+func (a *app) forward(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("forward", flag.ContinueOnError)
+	var locals, remotes, dynamics stringListFlag
+	flags.Var(&locals, "L", "Local forward `[bind_address:]port:host:hostport` (repeatable).")
+	flags.Var(&remotes, "R", "Remote forward `[bind_address:]port:host:hostport` (repeatable).")
+	flags.Var(&dynamics, "D", "Dynamic SOCKS5 proxy on `[bind_address:]port` (repeatable).")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if len(locals)+len(remotes)+len(dynamics) == 0 {
+		return fmt.Errorf("%w: at least one of -L, -R or -D is required", cli.ErrInvalidArgs)
+	}
+
+	if err := a.initClient(ctx); err != nil {
+		return err
+	}
+	if err := a.start(ctx); err != nil {
+		return err
+	}
+	e, err := a.getEnvironment(ctx)
+	if err != nil {
+		return err
+	}
+	client, err := a.dialSSH(e)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	var listeners []net.Listener
+	defer func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}()
+
+	for _, spec := range locals {
+		bindAddr, targetAddr, err := parseBindAndTarget(spec)
+		if err != nil {
+			return fmt.Errorf("invalid -L %q: %w", spec, err)
+		}
+		l, err := net.Listen("tcp", bindAddr)
+		if err != nil {
+			return fmt.Errorf("could not listen on %s: %w", bindAddr, err)
+		}
+		listeners = append(listeners, l)
+		a.logf("Local forward %s -> %s", bindAddr, targetAddr)
+		go acceptForwards(l, client.Dial, targetAddr, a.logf)
+	}
+
+	for _, spec := range remotes {
+		bindAddr, targetAddr, err := parseBindAndTarget(spec)
+		if err != nil {
+			return fmt.Errorf("invalid -R %q: %w", spec, err)
+		}
+		l, err := client.Listen("tcp", bindAddr)
+		if err != nil {
+			return fmt.Errorf("could not listen on remote %s: %w", bindAddr, err)
+		}
+		listeners = append(listeners, l)
+		a.logf("Remote forward %s -> %s", bindAddr, targetAddr)
+		go acceptForwards(l, net.Dial, targetAddr, a.logf)
+	}
+
+	for _, spec := range dynamics {
+		bindAddr, err := parseBindAddr(spec)
+		if err != nil {
+			return fmt.Errorf("invalid -D %q: %w", spec, err)
+		}
+		l, err := net.Listen("tcp", bindAddr)
+		if err != nil {
+			return fmt.Errorf("could not listen on %s: %w", bindAddr, err)
+		}
+		listeners = append(listeners, l)
+		a.logf("Dynamic SOCKS5 proxy on %s", bindAddr)
+		go func(l net.Listener) {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return // listener closed
+				}
+				go a.serveSOCKS5(conn, client)
+			}
+		}(l)
+	}
+
+	<-ctx.Done()
+	return nil
+}
+
+// acceptForwards accepts connections on l until it's closed, proxying each
+// one to targetAddr dialed through dial.
+func acceptForwards(l net.Listener, dial dialFunc, targetAddr string, logf func(string, ...any)) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return // listener closed
+		}
+		go proxyForward(dial, conn, targetAddr, logf)
+	}
+}
+
+// stringListFlag is a [flag.Value] that collects every occurrence of a flag
+// into a slice, so e.g. -L can be repeated to set up several forwards.
+type stringListFlag []string
+
+func (s *stringListFlag) String() string { return strings.Join(*s, ",") }
+
+func (s *stringListFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// parseBindAndTarget parses a "[bind_address:]port:host:hostport" forward
+// specification, as accepted by -L and -R.
+func parseBindAndTarget(spec string) (bindAddr, targetAddr string, err error) {
+	parts := strings.Split(spec, ":")
+	switch len(parts) {
+	case 3:
+		if _, err := strconv.Atoi(parts[0]); err != nil {
+			return "", "", fmt.Errorf("invalid port %q: %w", parts[0], err)
+		}
+		return net.JoinHostPort("127.0.0.1", parts[0]), net.JoinHostPort(parts[1], parts[2]), nil
+	case 4:
+		if _, err := strconv.Atoi(parts[1]); err != nil {
+			return "", "", fmt.Errorf("invalid port %q: %w", parts[1], err)
+		}
+		return net.JoinHostPort(parts[0], parts[1]), net.JoinHostPort(parts[2], parts[3]), nil
+	default:
+		return "", "", fmt.Errorf("expected format [bind_address:]port:host:hostport")
+	}
+}
+
+// parseBindAddr parses a "[bind_address:]port" specification, as accepted by
+// -D.
+func parseBindAddr(spec string) (string, error) {
+	parts := strings.Split(spec, ":")
+	switch len(parts) {
+	case 1:
+		if _, err := strconv.Atoi(parts[0]); err != nil {
+			return "", fmt.Errorf("invalid port %q: %w", parts[0], err)
+		}
+		return net.JoinHostPort("127.0.0.1", parts[0]), nil
+	case 2:
+		if _, err := strconv.Atoi(parts[1]); err != nil {
+			return "", fmt.Errorf("invalid port %q: %w", parts[1], err)
+		}
+		return net.JoinHostPort(parts[0], parts[1]), nil
+	default:
+		return "", fmt.Errorf("expected format [bind_address:]port")
+	}
+}
+
+// serveSOCKS5 handles a single SOCKS5 client connection on conn, supporting
+// only the CONNECT command, and proxies the resulting stream through the
+// upstream SSH client.
+func (a *app) serveSOCKS5(conn net.Conn, client *ssh.Client) {
+	defer conn.Close()
+
+	if err := socks5Handshake(conn); err != nil {
+		a.logf("forward: socks5 handshake failed: %v", err)
+		return
+	}
+
+	target, err := socks5ReadRequest(conn)
+	if err != nil {
+		a.logf("forward: socks5 request failed: %v", err)
+		return
+	}
+
+	remote, err := client.Dial("tcp", target)
+	if err != nil {
+		socks5Reply(conn, 0x05) // general SOCKS server failure
+		a.logf("forward: socks5 could not dial %s: %v", target, err)
+		return
+	}
+	defer remote.Close()
+
+	if err := socks5Reply(conn, 0x00); err != nil {
+		return
+	}
+
+	done := make(chan struct{}, 2)
+	go func() { io.Copy(remote, conn); done <- struct{}{} }()
+	go func() { io.Copy(conn, remote); done <- struct{}{} }()
+	<-done
+}
+
+// socks5Handshake performs the SOCKS5 method negotiation, accepting clients
+// unconditionally since no authentication is offered.
+func socks5Handshake(conn net.Conn) error {
+	var header [2]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return err
+	}
+	if header[0] != 0x05 {
+		return fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return err
+	}
+	// Reply that "no authentication required" (0x00) was selected.
+	_, err := conn.Write([]byte{0x05, 0x00})
+	return err
+}
+
+// socks5ReadRequest reads a SOCKS5 request and returns its destination
+// address, rejecting anything but the CONNECT command.
+func socks5ReadRequest(conn net.Conn) (string, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return "", err
+	}
+	if header[0] != 0x05 {
+		return "", fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+	if header[1] != 0x01 {
+		return "", fmt.Errorf("unsupported SOCKS command %d, only CONNECT is supported", header[1])
+	}
+
+	var host string
+	switch header[3] {
+	case 0x01: // IPv4 address
+		var addr [4]byte
+		if _, err := io.ReadFull(conn, addr[:]); err != nil {
+			return "", err
+		}
+		host = net.IP(addr[:]).String()
+	case 0x03: // domain name
+		var n [1]byte
+		if _, err := io.ReadFull(conn, n[:]); err != nil {
+			return "", err
+		}
+		buf := make([]byte, n[0])
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return "", err
+		}
+		host = string(buf)
+	case 0x04: // IPv6 address
+		var addr [16]byte
+		if _, err := io.ReadFull(conn, addr[:]); err != nil {
+			return "", err
+		}
+		host = net.IP(addr[:]).String()
+	default:
+		return "", fmt.Errorf("unsupported SOCKS address type %d", header[3])
+	}
+
+	var portBuf [2]byte
+	if _, err := io.ReadFull(conn, portBuf[:]); err != nil {
+		return "", err
+	}
+	port := int(portBuf[0])<<8 | int(portBuf[1])
+
+	return net.JoinHostPort(host, strconv.Itoa(port)), nil
+}
+
+// socks5Reply writes a minimal SOCKS5 reply with the given reply code and a
+// zeroed bind address, since the caller doesn't track the proxy's own bound
+// address.
+func socks5Reply(conn net.Conn, code byte) error {
+	reply := []byte{0x05, code, 0x00, 0x01, 0, 0, 0, 0, 0, 0}
+	_, err := conn.Write(reply)
+	return err
+}
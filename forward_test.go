@@ -0,0 +1,129 @@
+// © 2025 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the ISC
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseBindAndTarget(t *testing.T) {
+	cases := []struct {
+		spec           string
+		wantBindAddr   string
+		wantTargetAddr string
+		wantErr        bool
+	}{
+		{spec: "8080:localhost:80", wantBindAddr: "127.0.0.1:8080", wantTargetAddr: "localhost:80"},
+		{spec: "0.0.0.0:8080:localhost:80", wantBindAddr: "0.0.0.0:8080", wantTargetAddr: "localhost:80"},
+		{spec: "notaport:localhost:80", wantErr: true},
+		{spec: "0.0.0.0:notaport:localhost:80", wantErr: true},
+		{spec: "8080:localhost", wantErr: true},
+		{spec: "8080", wantErr: true},
+	}
+	for _, tc := range cases {
+		bindAddr, targetAddr, err := parseBindAndTarget(tc.spec)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("parseBindAndTarget(%q): got no error, want one", tc.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseBindAndTarget(%q): unexpected error: %v", tc.spec, err)
+			continue
+		}
+		if bindAddr != tc.wantBindAddr || targetAddr != tc.wantTargetAddr {
+			t.Errorf("parseBindAndTarget(%q) = (%q, %q), want (%q, %q)", tc.spec, bindAddr, targetAddr, tc.wantBindAddr, tc.wantTargetAddr)
+		}
+	}
+}
+
+func TestParseBindAddr(t *testing.T) {
+	cases := []struct {
+		spec    string
+		want    string
+		wantErr bool
+	}{
+		{spec: "1080", want: "127.0.0.1:1080"},
+		{spec: "0.0.0.0:1080", want: "0.0.0.0:1080"},
+		{spec: "notaport", wantErr: true},
+		{spec: "0.0.0.0:notaport", wantErr: true},
+		{spec: "a:b:c", wantErr: true},
+	}
+	for _, tc := range cases {
+		got, err := parseBindAddr(tc.spec)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("parseBindAddr(%q): got no error, want one", tc.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseBindAddr(%q): unexpected error: %v", tc.spec, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("parseBindAddr(%q) = %q, want %q", tc.spec, got, tc.want)
+		}
+	}
+}
+
+func TestSOCKS5ReadRequest(t *testing.T) {
+	cases := []struct {
+		name    string
+		req     []byte
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "IPv4",
+			req:  []byte{0x05, 0x01, 0x00, 0x01, 127, 0, 0, 1, 0x1f, 0x90}, // 127.0.0.1:8080
+			want: "127.0.0.1:8080",
+		},
+		{
+			name: "domain",
+			req:  append([]byte{0x05, 0x01, 0x00, 0x03, 9}, append([]byte("localhost"), 0x00, 0x50)...), // localhost:80
+			want: "localhost:80",
+		},
+		{
+			name:    "wrong version",
+			req:     []byte{0x04, 0x01, 0x00, 0x01, 127, 0, 0, 1, 0x1f, 0x90},
+			wantErr: true,
+		},
+		{
+			name:    "unsupported command",
+			req:     []byte{0x05, 0x02, 0x00, 0x01, 127, 0, 0, 1, 0x1f, 0x90}, // BIND, not CONNECT
+			wantErr: true,
+		},
+		{
+			name:    "unsupported address type",
+			req:     []byte{0x05, 0x01, 0x00, 0x7f, 0, 0, 0, 0, 0x1f, 0x90},
+			wantErr: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+			go client.Write(tc.req)
+
+			got, err := socks5ReadRequest(server)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("socks5ReadRequest(): got no error, want one")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("socks5ReadRequest(): unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("socks5ReadRequest() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
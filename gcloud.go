@@ -0,0 +1,73 @@
+// © 2025 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the ISC
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// gcloudTokenSource is an [oauth2.TokenSource] backed by the gcloud CLI. It
+// lets users who already have gcloud installed and logged in use cloudshell
+// without creating an OAuth client or setting up Application Default
+// Credentials of their own.
+type gcloudTokenSource struct{}
+
+// Token implements [oauth2.TokenSource].
+func (gcloudTokenSource) Token() (*oauth2.Token, error) {
+	out, err := exec.Command("gcloud", "auth", "print-access-token", "--format=json").Output()
+	if err != nil {
+		return nil, fmt.Errorf("gcloud auth print-access-token: %w", describeExecError(err))
+	}
+
+	var parsed struct {
+		Token  string `json:"token"`
+		Expiry string `json:"token_expiry"`
+	}
+	expiry := time.Now().Add(55 * time.Minute)
+	if err := json.Unmarshal(out, &parsed); err == nil && parsed.Token != "" {
+		if parsed.Expiry != "" {
+			if t, err := time.Parse(time.RFC3339, parsed.Expiry); err == nil {
+				expiry = t
+			}
+		}
+		return &oauth2.Token{AccessToken: parsed.Token, TokenType: "Bearer", Expiry: expiry}, nil
+	}
+
+	// Older gcloud versions print the bare token even with --format=json.
+	token := strings.TrimSpace(string(out))
+	if token == "" {
+		return nil, fmt.Errorf("gcloud auth print-access-token: empty output")
+	}
+	return &oauth2.Token{AccessToken: token, TokenType: "Bearer", Expiry: expiry}, nil
+}
+
+// gcloudAccount returns the email of the active gcloud account.
+func gcloudAccount() (string, error) {
+	out, err := exec.Command("gcloud", "config", "get-value", "account").Output()
+	if err != nil {
+		return "", fmt.Errorf("gcloud config get-value account: %w", describeExecError(err))
+	}
+	account := strings.TrimSpace(string(out))
+	if account == "" {
+		return "", fmt.Errorf("gcloud reports no active account, run %q", "gcloud auth login")
+	}
+	return account, nil
+}
+
+func describeExecError(err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+		return fmt.Errorf("%w: %s", err, bytes.TrimSpace(exitErr.Stderr))
+	}
+	return err
+}
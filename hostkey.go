@@ -0,0 +1,172 @@
+// © 2025 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the ISC
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.astrophena.name/base/cli"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// knownHostsPath returns the path of the trust-on-first-use host key
+// database used to verify the Cloud Shell SSH endpoint.
+func (a *app) knownHostsPath() string {
+	return filepath.Join(a.stateDir, "known_hosts")
+}
+
+// hostKeyCallback returns an [ssh.HostKeyCallback] backed by
+// stateDir/known_hosts. The first time a given host:port is seen, its key is
+// pinned (trust-on-first-use) and its fingerprint logged; on every later
+// connection the presented key must match the pinned one, or the connection
+// is refused.
+func (a *app) hostKeyCallback() (ssh.HostKeyCallback, error) {
+	path := a.knownHostsPath()
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		if err := os.WriteFile(path, nil, 0o600); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	verify, err := knownhosts.New(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := verify(hostname, remote, key)
+		if err == nil {
+			return nil
+		}
+
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) || len(keyErr.Want) != 0 {
+			return fmt.Errorf("REMOTE HOST IDENTIFICATION HAS CHANGED for %s: %w\n"+
+				"if this is expected (e.g. Cloud Shell rotated its host key), run \"cloudshell key hostkey forget %s\" and reconnect",
+				hostname, err, hostname)
+		}
+
+		// Key not found in known_hosts: trust it on first use.
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)
+		if _, err := fmt.Fprintln(f, line); err != nil {
+			return err
+		}
+		a.logf("Trusting new host key for %s (%s)", hostname, ssh.FingerprintSHA256(key))
+		return nil
+	}, nil
+}
+
+// keyHostkeyList prints the fingerprint of every host key pinned in
+// stateDir/known_hosts.
+func (a *app) keyHostkeyList(ctx context.Context) error {
+	if err := a.setupStateDir(ctx); err != nil {
+		return err
+	}
+	env := cli.GetEnv(ctx)
+
+	b, err := os.ReadFile(a.knownHostsPath())
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if len(b) == 0 {
+		a.logf("No host keys pinned yet.")
+		return nil
+	}
+
+	for len(b) > 0 {
+		_, hosts, pubKey, _, rest, err := ssh.ParseKnownHosts(b)
+		if err != nil {
+			break
+		}
+		fmt.Fprintf(env.Stdout, "%s %s %s\n", strings.Join(hosts, ","), pubKey.Type(), ssh.FingerprintSHA256(pubKey))
+		b = rest
+	}
+	return nil
+}
+
+// keyHostkeyForget removes any host key pinned for host (as passed to
+// "cloudshell key hostkey forget"), so the next connection re-pins whatever
+// key the server presents.
+func (a *app) keyHostkeyForget(ctx context.Context, host string) error {
+	if err := a.setupStateDir(ctx); err != nil {
+		return err
+	}
+	path := a.knownHostsPath()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("no host keys are pinned for %s", host)
+		}
+		return err
+	}
+
+	var kept []string
+	removed := 0
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		_, hosts, _, _, _, err := ssh.ParseKnownHosts([]byte(line + "\n"))
+		if err == nil && matchesHost(hosts, host) {
+			removed++
+			continue
+		}
+		kept = append(kept, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if removed == 0 {
+		return fmt.Errorf("no host keys are pinned for %s", host)
+	}
+
+	out := strings.Join(kept, "\n")
+	if len(kept) > 0 {
+		out += "\n"
+	}
+	if err := os.WriteFile(path, []byte(out), 0o600); err != nil {
+		return err
+	}
+	a.logf("Forgot %d host key(s) for %s.", removed, host)
+	return nil
+}
+
+// matchesHost reports whether any of a known_hosts entry's host patterns
+// refers to host, ignoring a bracketed or bare port suffix.
+func matchesHost(hosts []string, host string) bool {
+	for _, h := range hosts {
+		h = strings.TrimPrefix(h, "[")
+		if idx := strings.Index(h, "]:"); idx >= 0 {
+			h = h[:idx]
+		} else if idx := strings.LastIndex(h, ":"); idx >= 0 {
+			h = h[:idx]
+		}
+		if h == host {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,27 @@
+// © 2025 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the ISC
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import "testing"
+
+func TestMatchesHost(t *testing.T) {
+	cases := []struct {
+		hosts []string
+		host  string
+		want  bool
+	}{
+		{hosts: []string{"example.com"}, host: "example.com", want: true},
+		{hosts: []string{"example.com:22"}, host: "example.com", want: true},
+		{hosts: []string{"[example.com]:2222"}, host: "example.com", want: true},
+		{hosts: []string{"other.com"}, host: "example.com", want: false},
+		{hosts: []string{"other.com", "example.com"}, host: "example.com", want: true},
+		{hosts: nil, host: "example.com", want: false},
+	}
+	for _, tc := range cases {
+		if got := matchesHost(tc.hosts, tc.host); got != tc.want {
+			t.Errorf("matchesHost(%v, %q) = %v, want %v", tc.hosts, tc.host, got, tc.want)
+		}
+	}
+}
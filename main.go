@@ -6,13 +6,21 @@ package main
 
 import (
 	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"io/fs"
 	"net"
 	"net/http"
@@ -22,6 +30,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 	"unicode"
@@ -40,14 +49,28 @@ func main() { cli.Main(new(app)) }
 
 type app struct {
 	// configuration
-	stateDir       string
+	baseStateDir   string // state dir root, independent of the active profile
+	stateDir       string // baseStateDir, or baseStateDir/profile for a non-default profile
+	profile        string // selected via -profile, or persisted by "profile use"; resolved by setupStateDir
 	privateKeyPath string // path to the managed private SSH key
+	authMode       string // one of "auto", "oauth", "adc", "gcloud"
+	keyType        string // managed SSH key type: "ed25519", "rsa" or "ecdsa"
+	useAgent       bool   // authenticate SSH connections via ssh-agent instead of the managed key
 
 	// initialized by Run
-	httpc       *http.Client
-	logf        logger.Logf
-	oauthConfig *oauth2.Config
-	authed      bool
+	httpc            *http.Client
+	logf             logger.Logf
+	oauthConfig      *oauth2.Config
+	authed           bool
+	resolvedAuthMode string // the auth mode initClient actually picked, e.g. for -auth=auto
+}
+
+// Flags registers cloudshell's command-line flags.
+func (a *app) Flags(fs *flag.FlagSet) {
+	fs.StringVar(&a.authMode, "auth", "auto", "Authentication `mode` to use: auto, oauth, adc or gcloud.")
+	fs.StringVar(&a.profile, "profile", "", "Use the named `profile`'s state directory instead of the active one, without switching it (see 'profile use').")
+	fs.StringVar(&a.keyType, "key-type", "ed25519", "Managed SSH key `type` to generate: ed25519, rsa or ecdsa.")
+	fs.BoolVar(&a.useAgent, "use-agent", false, "Authenticate SSH connections via ssh-agent (SSH_AUTH_SOCK) instead of the managed key.")
 }
 
 func (a *app) Run(ctx context.Context) error {
@@ -63,12 +86,49 @@ func (a *app) Run(ctx context.Context) error {
 	case "info":
 		return a.info(ctx)
 	case "ssh":
-		return a.ssh(ctx)
+		return a.ssh(ctx, args)
 	case "start":
 		return a.start(ctx)
+	case "port-forward":
+		if len(args) == 0 {
+			return fmt.Errorf("%w: at least one forward spec (localport:host:hostport) is required", cli.ErrInvalidArgs)
+		}
+		return a.portForward(ctx, args)
+	case "forward":
+		return a.forward(ctx, args)
+	case "web":
+		return a.web(ctx, args)
+	case "cp":
+		return a.cp(ctx, args)
+	case "proxy":
+		return a.proxy(ctx, args)
+	case "ssh-config":
+		return a.sshConfig(ctx, args)
+	case "profile":
+		if len(args) == 0 {
+			return fmt.Errorf("%w: subcommand for 'profile' is required (list, use, remove)", cli.ErrInvalidArgs)
+		}
+		subcommand := args[0]
+		subargs := args[1:]
+		switch subcommand {
+		case "list":
+			return a.profileList(ctx)
+		case "use":
+			if len(subargs) == 0 {
+				return fmt.Errorf("%w: profile name is required", cli.ErrInvalidArgs)
+			}
+			return a.profileUse(ctx, subargs[0])
+		case "remove":
+			if len(subargs) == 0 {
+				return fmt.Errorf("%w: profile name is required", cli.ErrInvalidArgs)
+			}
+			return a.profileRemove(ctx, subargs[0])
+		default:
+			return fmt.Errorf("%w: unknown subcommand %q for profile", cli.ErrInvalidArgs, subcommand)
+		}
 	case "key":
 		if len(args) == 0 {
-			return fmt.Errorf("%w: subcommand for 'key' is required (list, add, remove)", cli.ErrInvalidArgs)
+			return fmt.Errorf("%w: subcommand for 'key' is required (list, add, remove, rotate, hostkey)", cli.ErrInvalidArgs)
 		}
 		subcommand := args[0]
 		subargs := args[1:]
@@ -85,6 +145,25 @@ func (a *app) Run(ctx context.Context) error {
 				return fmt.Errorf("%w: public key is required", cli.ErrInvalidArgs)
 			}
 			return a.keyRemove(ctx, subargs[0])
+		case "rotate":
+			return a.keyRotate(ctx)
+		case "hostkey":
+			if len(subargs) == 0 {
+				return fmt.Errorf("%w: subcommand for 'key hostkey' is required (list, forget)", cli.ErrInvalidArgs)
+			}
+			hkCommand := subargs[0]
+			hkArgs := subargs[1:]
+			switch hkCommand {
+			case "list":
+				return a.keyHostkeyList(ctx)
+			case "forget":
+				if len(hkArgs) == 0 {
+					return fmt.Errorf("%w: host is required", cli.ErrInvalidArgs)
+				}
+				return a.keyHostkeyForget(ctx, hkArgs[0])
+			default:
+				return fmt.Errorf("%w: unknown subcommand %q for key hostkey", cli.ErrInvalidArgs, hkCommand)
+			}
 		default:
 			return fmt.Errorf("%w: unknown subcommand %q for key", cli.ErrInvalidArgs, subcommand)
 		}
@@ -93,8 +172,9 @@ func (a *app) Run(ctx context.Context) error {
 	}
 }
 
-// ensureSSHKey checks for the existence of an RSA key pair in the state directory.
-// If it doesn't exist, it generates a new 4096-bit RSA key pair.
+// ensureSSHKey checks for the existence of a managed SSH key pair in the
+// state directory. If it doesn't exist, it generates a new one of the
+// configured -key-type.
 func (a *app) ensureSSHKey() error {
 	a.privateKeyPath = filepath.Join(a.stateDir, "key")
 	publicKeyPath := filepath.Join(a.stateDir, "key.pub")
@@ -103,41 +183,141 @@ func (a *app) ensureSSHKey() error {
 		return nil
 	}
 
-	a.logf("Generating a new SSH key pair for Cloud Shell...")
+	a.logf("Generating a new %s SSH key pair for Cloud Shell...", a.keyType)
+	if err := generateKeyPair(a.keyType, a.privateKeyPath, publicKeyPath); err != nil {
+		return err
+	}
+	a.logf("Key pair saved to %s and %s.", a.privateKeyPath, publicKeyPath)
+	return nil
+}
 
-	// Generate private key.
-	privateKey, err := rsa.GenerateKey(rand.Reader, 4096)
-	if err != nil {
-		return fmt.Errorf("failed to generate RSA key: %w", err)
+// generateKeyPair generates a new SSH key pair of the given type (one of
+// "ed25519", "rsa" or "ecdsa"; "" defaults to "ed25519") and writes it to
+// privPath (PEM-encoded private key, mode 0600) and pubPath (OpenSSH
+// authorized_keys format, mode 0644).
+func generateKeyPair(keyType, privPath, pubPath string) error {
+	switch keyType {
+	case "", "ed25519":
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return fmt.Errorf("failed to generate Ed25519 key: %w", err)
+		}
+		return writeGeneratedKeyPair(priv, privPath, pubPath)
+	case "ecdsa":
+		priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return fmt.Errorf("failed to generate ECDSA key: %w", err)
+		}
+		return writeGeneratedKeyPair(priv, privPath, pubPath)
+	case "rsa":
+		// Kept in the legacy PKCS#1 PEM format for backward compatibility with
+		// key files generated by older versions of cloudshell.
+		privateKey, err := rsa.GenerateKey(rand.Reader, 4096)
+		if err != nil {
+			return fmt.Errorf("failed to generate RSA key: %w", err)
+		}
+		privateKeyPEM := &pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
+		}
+		if err := os.WriteFile(privPath, pem.EncodeToMemory(privateKeyPEM), 0o600); err != nil {
+			return fmt.Errorf("failed to write private key: %w", err)
+		}
+		pub, err := ssh.NewPublicKey(&privateKey.PublicKey)
+		if err != nil {
+			return fmt.Errorf("failed to create public key: %w", err)
+		}
+		if err := os.WriteFile(pubPath, ssh.MarshalAuthorizedKey(pub), 0o644); err != nil {
+			return fmt.Errorf("failed to write public key: %w", err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown key type %q, must be one of ed25519, rsa, ecdsa", keyType)
 	}
+}
 
-	// Encode private key to PEM format.
-	privateKeyPEM := &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
+// writeGeneratedKeyPair PEM-encodes priv in OpenSSH private key format and
+// writes it alongside its public key, for the non-RSA key types.
+func writeGeneratedKeyPair(priv crypto.Signer, privPath, pubPath string) error {
+	signer, err := ssh.NewSignerFromSigner(priv)
+	if err != nil {
+		return fmt.Errorf("failed to create signer: %w", err)
+	}
+	marshaled, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		return fmt.Errorf("failed to marshal private key: %w", err)
 	}
-	if err := os.WriteFile(a.privateKeyPath, pem.EncodeToMemory(privateKeyPEM), 0o600); err != nil {
+	if err := os.WriteFile(privPath, pem.EncodeToMemory(marshaled), 0o600); err != nil {
 		return fmt.Errorf("failed to write private key: %w", err)
 	}
+	if err := os.WriteFile(pubPath, ssh.MarshalAuthorizedKey(signer.PublicKey()), 0o644); err != nil {
+		return fmt.Errorf("failed to write public key: %w", err)
+	}
+	return nil
+}
 
-	// Generate and write public key in OpenSSH format.
-	pub, err := ssh.NewPublicKey(&privateKey.PublicKey)
+// tokenFilePath returns the path of the file holding the cached OAuth token.
+func (a *app) tokenFilePath() string {
+	return filepath.Join(a.stateDir, "token.json")
+}
+
+// writeTokenFile writes tok to path atomically (via a temp file and rename)
+// with mode 0600, so a crash or concurrent read never observes a partial
+// file.
+func writeTokenFile(path string, tok *oauth2.Token) error {
+	b, err := json.MarshalIndent(tok, "", "  ")
 	if err != nil {
-		return fmt.Errorf("failed to create public key: %w", err)
+		return err
 	}
-	publicKeyBytes := ssh.MarshalAuthorizedKey(pub)
-	if err := os.WriteFile(publicKeyPath, publicKeyBytes, 0o644); err != nil {
-		return fmt.Errorf("failed to write public key: %w", err)
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".token-*.json.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
 	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
 
-	a.logf("Key pair saved to %s and %s.", a.privateKeyPath, publicKeyPath)
-	return nil
+// persistingTokenSource wraps an [oauth2.TokenSource] and writes every newly
+// minted token back to a file, so a refreshed access token survives past the
+// current process.
+type persistingTokenSource struct {
+	src  oauth2.TokenSource
+	path string
+
+	mu   sync.Mutex
+	last string // AccessToken of the last token written
+}
+
+func (p *persistingTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := p.src.Token()
+	if err != nil {
+		return nil, fmt.Errorf("%w; delete %s and re-run cloudshell to re-authenticate", err, p.path)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if tok.AccessToken != p.last {
+		if err := writeTokenFile(p.path, tok); err != nil {
+			return nil, fmt.Errorf("could not persist refreshed token: %w", err)
+		}
+		p.last = tok.AccessToken
+	}
+	return tok, nil
 }
 
 func (a *app) getToken(ctx context.Context) (*oauth2.Token, error) {
 	env := cli.GetEnv(ctx)
 
-	tokenFile := filepath.Join(a.stateDir, "token.json")
+	tokenFile := a.tokenFilePath()
 
 	tokb, err := os.ReadFile(tokenFile)
 	if err == nil {
@@ -147,94 +327,147 @@ func (a *app) getToken(ctx context.Context) (*oauth2.Token, error) {
 		}
 	}
 
+	// The OAuth flow must complete within a reasonable time, otherwise the
+	// local server is left running forever if the user never finishes it.
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+
 	// Start a local server to listen for the OAuth callback.
 	l, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
 		return nil, fmt.Errorf("could not start local server: %w", err)
 	}
 	defer l.Close()
-	a.oauthConfig.RedirectURL = fmt.Sprintf("http://%s", l.Addr().String())
-
-	// Channel to receive the authorization code.
-	codeCh := make(chan string)
-	// Channel to signal server shutdown.
-	shutdownCh := make(chan struct{})
-
-	srv := &http.Server{
-		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			code := r.URL.Query().Get("code")
-			if code == "" {
-				http.Error(w, "code not found", http.StatusBadRequest)
-				return
-			}
-			fmt.Fprintln(w, "Authentication successful! You can close this window now.")
-			codeCh <- code
-			// Signal server to shutdown.
-			shutdownCh <- struct{}{}
-		}),
+	a.oauthConfig.RedirectURL = fmt.Sprintf("http://%s/callback", l.Addr().String())
+
+	state, err := randomString(32)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate state: %w", err)
 	}
+	verifier, err := randomString(64) // 64 raw bytes -> 86 base64url chars, within the 43-128 PKCE range
+	if err != nil {
+		return nil, fmt.Errorf("could not generate PKCE code verifier: %w", err)
+	}
+	challenge := pkceChallenge(verifier)
 
-	// Start the server in a goroutine.
-	go func() {
-		if err := srv.Serve(l); err != http.ErrServerClosed {
-			a.logf("local server error: %v", err)
+	// Channel to receive the result of the callback (either a code or an error).
+	type callbackResult struct {
+		code string
+		err  error
+	}
+	resultCh := make(chan callbackResult, 1)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if errMsg := q.Get("error"); errMsg != "" {
+			writeCallbackPage(w, false, errMsg)
+			resultCh <- callbackResult{err: fmt.Errorf("authorization server returned error: %s", errMsg)}
+			return
 		}
-	}()
+		if got := q.Get("state"); got != state {
+			writeCallbackPage(w, false, "state mismatch")
+			resultCh <- callbackResult{err: errors.New("state parameter does not match, possible CSRF attempt")}
+			return
+		}
+		code := q.Get("code")
+		if code == "" {
+			writeCallbackPage(w, false, "code not found")
+			resultCh <- callbackResult{err: errors.New("authorization code not found in callback")}
+			return
+		}
+		writeCallbackPage(w, true, "")
+		resultCh <- callbackResult{code: code}
+	})
+	srv := &http.Server{Handler: mux}
 
-	// Shutdown the server gracefully when signaled.
+	// Start the server in a goroutine.
 	go func() {
-		select {
-		case <-shutdownCh:
-			if err := srv.Shutdown(ctx); err != nil {
-				a.logf("local server shutdown error: %v", err)
-			}
-		case <-ctx.Done():
-			return
+		if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			a.logf("local server error: %v", err)
 		}
 	}()
+	defer srv.Shutdown(context.Background())
 
-	authURL := a.oauthConfig.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
+	authURL := a.oauthConfig.AuthCodeURL(state, oauth2.AccessTypeOffline,
+		oauth2.SetAuthURLParam("code_challenge", challenge),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+		oauth2.SetAuthURLParam("prompt", "consent"),
+	)
 
-	// Try to open the browser automatically.
-	var opened bool
-	switch runtime.GOOS {
-	case "linux", "android":
-		if _, err := exec.LookPath("xdg-open"); err == nil {
-			if err := exec.Command("xdg-open", authURL).Start(); err == nil {
-				opened = true
-			}
-		}
-	case "darwin":
-		if _, err := exec.LookPath("open"); err == nil {
-			if err := exec.Command("open", authURL).Start(); err == nil {
-				opened = true
-			}
-		}
-	}
-
-	if !opened {
+	if !openBrowser(authURL) {
 		fmt.Fprintf(env.Stderr, "Go to the following link in your browser: %v\n", authURL)
 	}
 
 	select {
-	case authCode := <-codeCh:
-		newtok, err := a.oauthConfig.Exchange(ctx, authCode)
-		if err != nil {
-			return nil, err
+	case res := <-resultCh:
+		if res.err != nil {
+			return nil, res.err
 		}
-		tokb, err = json.MarshalIndent(newtok, "", "  ")
+		newtok, err := a.oauthConfig.Exchange(ctx, res.code,
+			oauth2.SetAuthURLParam("code_verifier", verifier),
+		)
 		if err != nil {
 			return nil, err
 		}
-		if err := os.WriteFile(tokenFile, tokb, 0o600); err != nil {
+		if newtok.RefreshToken == "" {
+			a.logf("warning: no refresh token was issued; cloudshell may need to re-authenticate once the access token expires")
+		}
+		if err := writeTokenFile(tokenFile, newtok); err != nil {
 			return nil, err
 		}
 		return newtok, nil
 	case <-ctx.Done():
-		return nil, ctx.Err()
+		return nil, fmt.Errorf("timed out waiting for the OAuth callback: %w", ctx.Err())
 	}
 }
 
+// randomString returns a URL-safe base64-encoded string derived from n
+// cryptographically random bytes, suitable for use as an OAuth state value or
+// PKCE code verifier.
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// pkceChallenge derives a PKCE S256 code challenge from a code verifier, as
+// defined in RFC 7636.
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// writeCallbackPage renders a minimal HTML page shown in the browser after
+// the OAuth callback is handled.
+func writeCallbackPage(w http.ResponseWriter, ok bool, reason string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if ok {
+		fmt.Fprint(w, "<html><body><h1>Authentication successful</h1><p>You can close this window now.</p></body></html>")
+		return
+	}
+	w.WriteHeader(http.StatusBadRequest)
+	fmt.Fprintf(w, "<html><body><h1>Authentication failed</h1><p>%s</p></body></html>", reason)
+}
+
+// openBrowser tries to open url in the user's default browser, returning
+// whether it succeeded.
+func openBrowser(url string) bool {
+	switch runtime.GOOS {
+	case "linux", "android":
+		if _, err := exec.LookPath("xdg-open"); err == nil {
+			return exec.Command("xdg-open", url).Start() == nil
+		}
+	case "darwin":
+		if _, err := exec.LookPath("open"); err == nil {
+			return exec.Command("open", url).Start() == nil
+		}
+	}
+	return false
+}
+
 type environment struct {
 	DockerImage string   `json:"dockerImage"`
 	State       string   `json:"state"`
@@ -259,15 +492,17 @@ func makeRequest[Response any](ctx context.Context, httpc *http.Client, method,
 	})
 }
 
-func (a *app) initClient(ctx context.Context) error {
-	if a.authed {
-		return nil
-	}
-
+// setupStateDir resolves and creates the application's state directory,
+// populating a.stateDir and a.logf. It's idempotent and safe to call before
+// initClient, e.g. from commands that don't need to authenticate.
+func (a *app) setupStateDir(ctx context.Context) error {
 	env := cli.GetEnv(ctx)
-
 	a.logf = env.Logf
 
+	if a.stateDir != "" {
+		return nil
+	}
+
 	xdgStateDir := env.Getenv("XDG_STATE_HOME")
 	if xdgStateDir == "" {
 		home, err := os.UserHomeDir()
@@ -276,32 +511,99 @@ func (a *app) initClient(ctx context.Context) error {
 		}
 		xdgStateDir = filepath.Join(home, ".local", "state")
 	}
-	a.stateDir = filepath.Join(xdgStateDir, "cloudshell")
-	if err := os.MkdirAll(a.stateDir, 0o700); err != nil {
+	a.baseStateDir = filepath.Join(xdgStateDir, "cloudshell")
+	if err := os.MkdirAll(a.baseStateDir, 0o700); err != nil {
 		return err
 	}
 
-	clientSecret, err := os.ReadFile(filepath.Join(a.stateDir, "client_secret.json"))
+	profile, err := a.currentProfile()
 	if err != nil {
-		if errors.Is(err, fs.ErrNotExist) {
-			return fmt.Errorf("client_secret.json is missing in %s, see https://go.astrophena.name/cloudshell#hdr-Setup for setup instructions", a.stateDir)
-		}
 		return err
 	}
-	a.oauthConfig, err = google.ConfigFromJSON(clientSecret, "https://www.googleapis.com/auth/cloud-platform")
-	if err != nil {
+	a.profile = profile
+
+	a.stateDir = a.baseStateDir
+	if profile != defaultProfile {
+		a.stateDir = filepath.Join(a.baseStateDir, profile)
+	}
+	return os.MkdirAll(a.stateDir, 0o700)
+}
+
+func (a *app) initClient(ctx context.Context) error {
+	if a.authed {
+		return nil
+	}
+
+	if err := a.setupStateDir(ctx); err != nil {
 		return err
 	}
-	tok, err := a.getToken(ctx)
-	if err != nil {
+
+	clientSecretPath := filepath.Join(a.stateDir, "client_secret.json")
+	clientSecret, err := os.ReadFile(clientSecretPath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
 		return err
 	}
-	a.httpc = a.oauthConfig.Client(ctx, tok)
+
+	mode := a.authMode
+	if mode == "" || mode == "auto" {
+		mode = detectAuthMode(ctx, clientSecret != nil)
+	}
+
+	switch mode {
+	case "oauth":
+		if clientSecret == nil {
+			return fmt.Errorf("client_secret.json is missing in %s, see https://go.astrophena.name/cloudshell#hdr-Setup for setup instructions", a.stateDir)
+		}
+		a.oauthConfig, err = google.ConfigFromJSON(clientSecret, "https://www.googleapis.com/auth/cloud-platform")
+		if err != nil {
+			return err
+		}
+		tok, err := a.getToken(ctx)
+		if err != nil {
+			return err
+		}
+		ts := &persistingTokenSource{
+			src:  oauth2.ReuseTokenSource(tok, a.oauthConfig.TokenSource(ctx, tok)),
+			path: a.tokenFilePath(),
+			last: tok.AccessToken,
+		}
+		a.httpc = oauth2.NewClient(ctx, ts)
+	case "adc":
+		creds, err := google.FindDefaultCredentials(ctx, "https://www.googleapis.com/auth/cloud-platform")
+		if err != nil {
+			return fmt.Errorf("could not find Application Default Credentials: %w (run \"gcloud auth application-default login\" or set GOOGLE_APPLICATION_CREDENTIALS)", err)
+		}
+		a.httpc = oauth2.NewClient(ctx, creds.TokenSource)
+	case "gcloud":
+		if _, err := exec.LookPath("gcloud"); err != nil {
+			return fmt.Errorf("gcloud is not on PATH: %w", err)
+		}
+		a.httpc = oauth2.NewClient(ctx, oauth2.ReuseTokenSource(nil, gcloudTokenSource{}))
+	default:
+		return fmt.Errorf("%w: unknown auth mode %q", cli.ErrInvalidArgs, mode)
+	}
+	a.resolvedAuthMode = mode
 	a.authed = true
 
 	return nil
 }
 
+// detectAuthMode picks an authentication mode for "-auth=auto": oauth if a
+// client_secret.json is configured, otherwise Application Default
+// Credentials if they're available, falling back to gcloud if it's on PATH.
+func detectAuthMode(ctx context.Context, hasClientSecret bool) string {
+	if hasClientSecret {
+		return "oauth"
+	}
+	if _, err := google.FindDefaultCredentials(ctx, "https://www.googleapis.com/auth/cloud-platform"); err == nil {
+		return "adc"
+	}
+	if _, err := exec.LookPath("gcloud"); err == nil {
+		return "gcloud"
+	}
+	return "adc" // surface FindDefaultCredentials' error for the user to act on
+}
+
 func (a *app) info(ctx context.Context) error {
 	if err := a.initClient(ctx); err != nil {
 		return err
@@ -312,6 +614,12 @@ func (a *app) info(ctx context.Context) error {
 		return err
 	}
 
+	if a.resolvedAuthMode == "gcloud" {
+		if account, err := gcloudAccount(); err == nil {
+			a.logf("Account: %s", account)
+		}
+	}
+
 	state := strings.ToLower(env.State)
 	state = uppercaseFirst(state) + "."
 	a.logf(state)
@@ -343,7 +651,19 @@ func uppercaseFirst(s string) string {
 	return string(runes)
 }
 
-func (a *app) ssh(ctx context.Context) error {
+func (a *app) ssh(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("ssh", flag.ContinueOnError)
+	record := flags.Bool("record", false, "Record the session to a timestamped file in stateDir/sessions/.")
+	recordTo := flags.String("record-to", "", "Record the session to the given `path` (implies -record).")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	command := flags.Args()
+	if len(command) > 0 && (*record || *recordTo != "") {
+		return fmt.Errorf("%w: -record/-record-to aren't supported with a trailing command, session recording only applies to interactive shells", cli.ErrInvalidArgs)
+	}
+
 	if err := a.initClient(ctx); err != nil {
 		return err
 	}
@@ -354,31 +674,85 @@ func (a *app) ssh(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	return a.sshExec(ctx, env)
+
+	if len(command) > 0 {
+		return a.sshRun(ctx, env, command)
+	}
+
+	recordPath := *recordTo
+	if recordPath == "" && *record {
+		sessionsDir := filepath.Join(a.stateDir, "sessions")
+		if err := os.MkdirAll(sessionsDir, 0o700); err != nil {
+			return err
+		}
+		recordPath = filepath.Join(sessionsDir, time.Now().UTC().Format("20060102T150405Z")+".cast")
+	}
+
+	return a.sshExec(ctx, env, recordPath)
+}
+
+// sshRun runs command non-interactively over SSH (as in "cloudshell ssh --
+// <command...>"), with stdin/stdout/stderr wired straight through, and
+// terminates the process with the remote command's own exit code.
+func (a *app) sshRun(ctx context.Context, e environment, command []string) error {
+	env := cli.GetEnv(ctx)
+
+	client, err := a.dialSSH(e)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	defer session.Close()
+
+	session.Stdin = env.Stdin
+	session.Stdout = env.Stdout
+	session.Stderr = env.Stderr
+
+	err = session.Run(strings.Join(command, " "))
+	if err == nil {
+		return nil
+	}
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		os.Exit(exitErr.ExitStatus())
+	}
+	return fmt.Errorf("failed to run command: %w", err)
 }
 
 func (a *app) start(ctx context.Context) error {
 	if err := a.initClient(ctx); err != nil {
 		return err
 	}
+
+	if a.useAgent {
+		return a.startWithAgent(ctx)
+	}
+
 	if err := a.ensureSSHKey(); err != nil {
 		return fmt.Errorf("failed to ensure SSH key: %w", err)
 	}
-
 	publicKeyPath := filepath.Join(a.stateDir, "key.pub")
 	pubKeyBytes, err := os.ReadFile(publicKeyPath)
 	if err != nil {
 		return fmt.Errorf("could not read managed public key: %w", err)
 	}
+	// Cloud Shell API returns Internal Server Error when SSH public key has a
+	// newline in the end. So trim it.
+	return a.startEnvironment(ctx, []string{strings.TrimSuffix(string(pubKeyBytes), "\n")})
+}
+
+// startEnvironment calls the Cloud Shell ":start" API authorizing pubKeys
+// for SSH access, then waits until the environment reports RUNNING.
+func (a *app) startEnvironment(ctx context.Context, pubKeys []string) error {
 	type startRequest struct {
 		PublicKeys []string `json:"publicKeys"`
 	}
-	req := startRequest{
-		// Cloud Shell API returns Internal Server Error when SSH public key has a
-		// newline in the end. So trim it.
-		PublicKeys: []string{strings.TrimSuffix(string(pubKeyBytes), "\n")},
-	}
-	if _, err := makeRequest[request.IgnoreResponse](ctx, a.httpc, http.MethodPost, ":start", req); err != nil {
+	if _, err := makeRequest[request.IgnoreResponse](ctx, a.httpc, http.MethodPost, ":start", startRequest{PublicKeys: pubKeys}); err != nil {
 		return err
 	}
 	a.logf("Environment is starting...")
@@ -402,38 +776,88 @@ func (a *app) start(ctx context.Context) error {
 	}
 }
 
-// sshExec establishes an interactive SSH session using the native Go SSH client.
-func (a *app) sshExec(ctx context.Context, e environment) error {
-	env := cli.GetEnv(ctx)
+// startWithAgent starts the environment, authorized with whatever keys it
+// already has, then authorizes every ssh-agent identity that isn't in its
+// PublicKeys yet. Identities already present are left untouched, so re-runs
+// don't keep re-adding the same key.
+func (a *app) startWithAgent(ctx context.Context) error {
+	e, err := a.getEnvironment(ctx)
+	if err != nil {
+		return err
+	}
+
+	if e.State != "RUNNING" {
+		if err := a.startEnvironment(ctx, e.PublicKeys); err != nil {
+			return err
+		}
+		e, err = a.getEnvironment(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	agentKeys, err := agentPublicKeys()
+	if err != nil {
+		return err
+	}
 
+	authorized := make(map[string]bool, len(e.PublicKeys))
+	for _, k := range e.PublicKeys {
+		authorized[k] = true
+	}
+
+	for _, k := range agentKeys {
+		if authorized[k] {
+			continue
+		}
+		if err := a.keyAdd(ctx, k); err != nil {
+			return fmt.Errorf("could not authorize ssh-agent identity: %w", err)
+		}
+	}
+	return nil
+}
+
+// dialSSH authenticates with the managed private key and dials the
+// environment's SSH endpoint, returning a ready-to-use client. Callers are
+// responsible for closing it.
+func (a *app) dialSSH(e environment) (*ssh.Client, error) {
 	if e.SSHHost == "" || e.SSHPort == 0 || e.SSHUsername == "" {
-		return errors.New("connection with SSH is unavailable")
+		return nil, errors.New("connection with SSH is unavailable")
 	}
 
-	// Read and parse the private key for authentication.
-	key, err := os.ReadFile(a.privateKeyPath)
+	authMethod, err := a.sshAuthMethod()
 	if err != nil {
-		return fmt.Errorf("failed to read private key: %w", err)
+		return nil, err
 	}
-	signer, err := ssh.ParsePrivateKey(key)
+
+	hostKeyCallback, err := a.hostKeyCallback()
 	if err != nil {
-		return fmt.Errorf("failed to parse private key: %w", err)
+		return nil, fmt.Errorf("could not set up host key verification: %w", err)
 	}
 
 	config := &ssh.ClientConfig{
-		User: e.SSHUsername,
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(signer),
-		},
-		// Equivalent to "-o StrictHostKeyChecking=no". This is safe because
-		// the host is provided by the trusted Google Cloud API.
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		User:            e.SSHUsername,
+		Auth:            []ssh.AuthMethod{authMethod},
+		HostKeyCallback: hostKeyCallback,
 	}
 
 	addr := net.JoinHostPort(e.SSHHost, fmt.Sprintf("%d", e.SSHPort))
 	client, err := ssh.Dial("tcp", addr, config)
 	if err != nil {
-		return fmt.Errorf("failed to dial: %w", err)
+		return nil, fmt.Errorf("failed to dial: %w", err)
+	}
+	return client, nil
+}
+
+// sshExec establishes an interactive SSH session using the native Go SSH
+// client. If recordPath is non-empty, the session is additionally recorded
+// to an asciicast v2 file at that path (with a ".typescript" sidecar).
+func (a *app) sshExec(ctx context.Context, e environment, recordPath string) error {
+	env := cli.GetEnv(ctx)
+
+	client, err := a.dialSSH(e)
+	if err != nil {
+		return err
 	}
 	defer client.Close()
 
@@ -472,6 +896,16 @@ func (a *app) sshExec(ctx context.Context, e environment) error {
 		return fmt.Errorf("failed to request pty: %w", err)
 	}
 
+	var rec *sessionRecorder
+	if recordPath != "" {
+		rec, err = newSessionRecorder(recordPath, width, height, e)
+		if err != nil {
+			return fmt.Errorf("failed to start session recording: %w", err)
+		}
+		defer rec.Close()
+		a.logf("Recording session to %s", recordPath)
+	}
+
 	// Handle terminal resizing.
 	// Create a channel to receive window change signals.
 	winch := make(chan os.Signal, 1)
@@ -484,11 +918,17 @@ func (a *app) sshExec(ctx context.Context, e environment) error {
 			}
 			// Send a "window-change" request to the remote server.
 			session.WindowChange(h, w)
+			if rec != nil {
+				rec.Resize(w, h)
+			}
 		}
 	}()
 
 	// Connect local I/O to the remote session.
 	session.Stdout = env.Stdout
+	if rec != nil {
+		session.Stdout = io.MultiWriter(env.Stdout, rec)
+	}
 	session.Stderr = env.Stderr
 	session.Stdin = env.Stdin
 
@@ -552,3 +992,80 @@ func (a *app) keyRemove(ctx context.Context, key string) error {
 	a.logf("Public key removed successfully.")
 	return nil
 }
+
+// keyRotate generates a fresh managed SSH key pair, authorizes it with the
+// environment, waits for the environment to pick it up, atomically swaps the
+// key files in stateDir, then deauthorizes the old key.
+func (a *app) keyRotate(ctx context.Context) error {
+	if err := a.initClient(ctx); err != nil {
+		return err
+	}
+
+	privPath := filepath.Join(a.stateDir, "key")
+	pubPath := filepath.Join(a.stateDir, "key.pub")
+
+	var oldPubKey string
+	if b, err := os.ReadFile(pubPath); err == nil {
+		oldPubKey = strings.TrimSuffix(string(b), "\n")
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	newPrivPath := privPath + ".new"
+	newPubPath := pubPath + ".new"
+	if err := generateKeyPair(a.keyType, newPrivPath, newPubPath); err != nil {
+		return fmt.Errorf("failed to generate new key pair: %w", err)
+	}
+	newPubBytes, err := os.ReadFile(newPubPath)
+	if err != nil {
+		return err
+	}
+	newPubKey := strings.TrimSuffix(string(newPubBytes), "\n")
+
+	if err := a.keyAdd(ctx, newPubKey); err != nil {
+		return fmt.Errorf("could not authorize new key: %w", err)
+	}
+
+	a.logf("Waiting for the environment to pick up the new key...")
+	ticker := time.NewTicker(3 * time.Second)
+	defer ticker.Stop()
+waitForPickup:
+	for {
+		e, err := a.getEnvironment(ctx)
+		if err != nil {
+			return err
+		}
+		for _, k := range e.PublicKeys {
+			if k == newPubKey {
+				break waitForPickup
+			}
+		}
+		select {
+		case <-ticker.C:
+			continue
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	// Swap the key files into place before deauthorizing the old key, so that
+	// an interruption between the two leaves both keys authorized (the user
+	// can still connect) rather than leaving stateDir/key stale and locking
+	// the user out until the old key is deauthorized by hand.
+	if err := os.Rename(newPrivPath, privPath); err != nil {
+		return fmt.Errorf("could not swap private key: %w", err)
+	}
+	if err := os.Rename(newPubPath, pubPath); err != nil {
+		return fmt.Errorf("could not swap public key: %w", err)
+	}
+	a.privateKeyPath = privPath
+
+	if oldPubKey != "" {
+		if err := a.keyRemove(ctx, oldPubKey); err != nil {
+			return fmt.Errorf("could not deauthorize old key: %w", err)
+		}
+	}
+
+	a.logf("Rotated the managed SSH key.")
+	return nil
+}
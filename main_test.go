@@ -0,0 +1,33 @@
+// © 2025 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the ISC
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import "testing"
+
+func TestRandomString(t *testing.T) {
+	a, err := randomString(32)
+	if err != nil {
+		t.Fatalf("randomString(32): unexpected error: %v", err)
+	}
+	b, err := randomString(32)
+	if err != nil {
+		t.Fatalf("randomString(32): unexpected error: %v", err)
+	}
+	if a == b {
+		t.Errorf("randomString(32) returned the same value twice: %q", a)
+	}
+	if a == "" {
+		t.Errorf("randomString(32) returned an empty string")
+	}
+}
+
+func TestPKCEChallenge(t *testing.T) {
+	// Known-answer test vector from RFC 7636 appendix B.
+	const verifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	const want = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	if got := pkceChallenge(verifier); got != want {
+		t.Errorf("pkceChallenge(%q) = %q, want %q", verifier, got, want)
+	}
+}
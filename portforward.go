@@ -0,0 +1,122 @@
+// © 2025 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the ISC
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// portForward implements the "port-forward" command: it tunnels one or more
+// local TCP ports through the Cloud Shell SSH connection, similar to what
+// "gcloud cloud-shell ssh -- -L" provides.
+func (a *app) portForward(ctx context.Context, specs []string) error {
+	forwards := make([]forwardSpec, 0, len(specs))
+	for _, spec := range specs {
+		f, err := parseForwardSpec(spec)
+		if err != nil {
+			return fmt.Errorf("invalid forward spec %q: %w", spec, err)
+		}
+		forwards = append(forwards, f)
+	}
+
+	if err := a.initClient(ctx); err != nil {
+		return err
+	}
+	if err := a.start(ctx); err != nil {
+		return err
+	}
+	e, err := a.getEnvironment(ctx)
+	if err != nil {
+		return err
+	}
+
+	client, err := a.dialSSH(e)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	listeners := make([]net.Listener, 0, len(forwards))
+	defer func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}()
+
+	for _, f := range forwards {
+		l, err := net.Listen("tcp", f.localAddr)
+		if err != nil {
+			return fmt.Errorf("could not listen on %s: %w", f.localAddr, err)
+		}
+		listeners = append(listeners, l)
+		a.logf("Forwarding %s -> %s", f.localAddr, f.remoteAddr)
+
+		go func(l net.Listener, remoteAddr string) {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return // listener closed
+				}
+				go proxyForward(client.Dial, conn, remoteAddr, a.logf)
+			}
+		}(l, f.remoteAddr)
+	}
+
+	<-ctx.Done()
+	return nil
+}
+
+type forwardSpec struct {
+	localAddr  string
+	remoteAddr string
+}
+
+// parseForwardSpec parses a "localport:host:hostport" forward specification,
+// as accepted by the "port-forward" command.
+func parseForwardSpec(spec string) (forwardSpec, error) {
+	parts := strings.SplitN(spec, ":", 3)
+	if len(parts) != 3 {
+		return forwardSpec{}, fmt.Errorf("expected format localport:host:hostport")
+	}
+	if _, err := strconv.Atoi(parts[0]); err != nil {
+		return forwardSpec{}, fmt.Errorf("invalid local port %q: %w", parts[0], err)
+	}
+	return forwardSpec{
+		localAddr:  net.JoinHostPort("127.0.0.1", parts[0]),
+		remoteAddr: net.JoinHostPort(parts[1], parts[2]),
+	}, nil
+}
+
+// dialFunc matches the signature of (*ssh.Client).Dial.
+type dialFunc func(network, addr string) (net.Conn, error)
+
+// proxyForward copies data bidirectionally between local and a connection
+// dialed through dial, closing both ends once either side is done.
+func proxyForward(dial dialFunc, local net.Conn, remoteAddr string, logf func(string, ...any)) {
+	defer local.Close()
+
+	remote, err := dial("tcp", remoteAddr)
+	if err != nil {
+		logf("port-forward: could not dial %s: %v", remoteAddr, err)
+		return
+	}
+	defer remote.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(remote, local)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(local, remote)
+		done <- struct{}{}
+	}()
+	<-done
+}
@@ -0,0 +1,38 @@
+// © 2025 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the ISC
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import "testing"
+
+func TestParseForwardSpec(t *testing.T) {
+	cases := []struct {
+		spec           string
+		wantLocalAddr  string
+		wantRemoteAddr string
+		wantErr        bool
+	}{
+		{spec: "8080:localhost:80", wantLocalAddr: "127.0.0.1:8080", wantRemoteAddr: "localhost:80"},
+		{spec: "2222:10.0.0.1:22", wantLocalAddr: "127.0.0.1:2222", wantRemoteAddr: "10.0.0.1:22"},
+		{spec: "notaport:localhost:80", wantErr: true},
+		{spec: "8080:localhost", wantErr: true},
+		{spec: "8080", wantErr: true},
+	}
+	for _, tc := range cases {
+		got, err := parseForwardSpec(tc.spec)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("parseForwardSpec(%q): got no error, want one", tc.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseForwardSpec(%q): unexpected error: %v", tc.spec, err)
+			continue
+		}
+		if got.localAddr != tc.wantLocalAddr || got.remoteAddr != tc.wantRemoteAddr {
+			t.Errorf("parseForwardSpec(%q) = %+v, want {%s %s}", tc.spec, got, tc.wantLocalAddr, tc.wantRemoteAddr)
+		}
+	}
+}
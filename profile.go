@@ -0,0 +1,221 @@
+// © 2025 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the ISC
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"go.astrophena.name/base/cli"
+)
+
+// defaultProfile is the name of the implicit profile used when none has been
+// selected. Unlike every other profile, its state files live directly in the
+// base state directory, for backward compatibility with installs that
+// predate profile support.
+const defaultProfile = "default"
+
+// currentProfileFilePath returns the path of the file holding the name of
+// the profile last selected with "cloudshell profile use", consulted when
+// -profile isn't given.
+func (a *app) currentProfileFilePath() string {
+	return filepath.Join(a.baseStateDir, "current_profile")
+}
+
+// profilesFilePath returns the path of the file listing every profile ever
+// created with "cloudshell profile use", other than "default".
+func (a *app) profilesFilePath() string {
+	return filepath.Join(a.baseStateDir, "profiles.json")
+}
+
+// readProfiles returns the names of every profile created with "cloudshell
+// profile use", other than "default".
+func (a *app) readProfiles() ([]string, error) {
+	b, err := os.ReadFile(a.profilesFilePath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var profiles []string
+	if err := json.Unmarshal(b, &profiles); err != nil {
+		return nil, err
+	}
+	return profiles, nil
+}
+
+// writeProfiles atomically (via a temp file and rename) persists the list of
+// known profiles.
+func (a *app) writeProfiles(profiles []string) error {
+	b, err := json.MarshalIndent(profiles, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(a.baseStateDir, ".profiles-*.json.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), a.profilesFilePath())
+}
+
+// currentProfile returns the name of the profile currently selected by
+// -profile or a prior "cloudshell profile use", without resolving a.stateDir.
+// It requires a.baseStateDir to already be set up.
+func (a *app) currentProfile() (string, error) {
+	if a.profile != "" {
+		return a.profile, nil
+	}
+	return a.persistedProfile()
+}
+
+// persistedProfile returns the name of the profile last selected with
+// "cloudshell profile use", ignoring any one-off -profile override. It
+// requires a.baseStateDir to already be set up.
+func (a *app) persistedProfile() (string, error) {
+	b, err := os.ReadFile(a.currentProfileFilePath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return defaultProfile, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	name := strings.TrimSpace(string(b))
+	if name == "" {
+		return defaultProfile, nil
+	}
+	return name, nil
+}
+
+// validateProfileName rejects profile names that can't safely be used as a
+// single path component under the state directory.
+func validateProfileName(name string) error {
+	if name == "" {
+		return fmt.Errorf("%w: profile name is required", cli.ErrInvalidArgs)
+	}
+	if name != filepath.Base(name) || name == "." || name == ".." {
+		return fmt.Errorf("%w: invalid profile name %q", cli.ErrInvalidArgs, name)
+	}
+	return nil
+}
+
+// profileList prints every known profile, marking the currently active one.
+func (a *app) profileList(ctx context.Context) error {
+	if err := a.setupStateDir(ctx); err != nil {
+		return err
+	}
+	env := cli.GetEnv(ctx)
+
+	others, err := a.readProfiles()
+	if err != nil {
+		return err
+	}
+	sort.Strings(others)
+	names := append([]string{defaultProfile}, others...)
+
+	for _, name := range names {
+		marker := "  "
+		if name == a.profile {
+			marker = "* "
+		}
+		fmt.Fprintf(env.Stdout, "%s%s\n", marker, name)
+	}
+	return nil
+}
+
+// profileUse switches the active profile, persisting the choice so that
+// subsequent commands use it without needing -profile. A profile other than
+// "default" is created (as an empty state directory) the first time it's
+// used.
+func (a *app) profileUse(ctx context.Context, name string) error {
+	if err := validateProfileName(name); err != nil {
+		return err
+	}
+	if err := a.setupStateDir(ctx); err != nil {
+		return err
+	}
+
+	if name != defaultProfile {
+		profiles, err := a.readProfiles()
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(profiles, name) {
+			profiles = append(profiles, name)
+			if err := a.writeProfiles(profiles); err != nil {
+				return err
+			}
+		}
+		if err := os.MkdirAll(filepath.Join(a.baseStateDir, name), 0o700); err != nil {
+			return err
+		}
+	}
+
+	if err := os.WriteFile(a.currentProfileFilePath(), []byte(name), 0o600); err != nil {
+		return err
+	}
+	a.logf("Switched to profile %q.", name)
+	return nil
+}
+
+// profileRemove deletes a profile's state directory and forgets it,
+// switching the active profile back to "default" if it was the one removed.
+// The "default" profile itself can't be removed.
+func (a *app) profileRemove(ctx context.Context, name string) error {
+	if err := validateProfileName(name); err != nil {
+		return err
+	}
+	if name == defaultProfile {
+		return fmt.Errorf("%w: the %q profile can't be removed", cli.ErrInvalidArgs, defaultProfile)
+	}
+	if err := a.setupStateDir(ctx); err != nil {
+		return err
+	}
+
+	profiles, err := a.readProfiles()
+	if err != nil {
+		return err
+	}
+	idx := slices.Index(profiles, name)
+	if idx == -1 {
+		return fmt.Errorf("%w: no such profile %q", cli.ErrInvalidArgs, name)
+	}
+	if err := a.writeProfiles(slices.Delete(profiles, idx, idx+1)); err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(filepath.Join(a.baseStateDir, name)); err != nil {
+		return err
+	}
+
+	active, err := a.persistedProfile()
+	if err != nil {
+		return err
+	}
+	if active == name {
+		if err := os.WriteFile(a.currentProfileFilePath(), []byte(defaultProfile), 0o600); err != nil {
+			return err
+		}
+	}
+
+	a.logf("Removed profile %q.", name)
+	return nil
+}
@@ -0,0 +1,32 @@
+// © 2025 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the ISC
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import "testing"
+
+func TestValidateProfileName(t *testing.T) {
+	cases := []struct {
+		name    string
+		wantErr bool
+	}{
+		{name: "work"},
+		{name: "personal-2"},
+		{name: "", wantErr: true},
+		{name: ".", wantErr: true},
+		{name: "..", wantErr: true},
+		{name: "foo/bar", wantErr: true},
+		{name: "/etc/passwd", wantErr: true},
+		{name: "../escape", wantErr: true},
+	}
+	for _, tc := range cases {
+		err := validateProfileName(tc.name)
+		if tc.wantErr && err == nil {
+			t.Errorf("validateProfileName(%q): got no error, want one", tc.name)
+		}
+		if !tc.wantErr && err != nil {
+			t.Errorf("validateProfileName(%q): unexpected error: %v", tc.name, err)
+		}
+	}
+}
@@ -0,0 +1,396 @@
+// © 2025 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the ISC
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+
+	"go.astrophena.name/base/cli"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// proxy implements the "proxy" command: it runs a small SSH server on
+// localhost that transparently forwards every session, exec, subsystem
+// (e.g. sftp) and direct-tcpip channel to the upstream Cloud Shell
+// environment. This lets users point a regular ssh/scp/rsync/Remote-SSH
+// client at a fixed local address instead of invoking "cloudshell ssh" every
+// time.
+func (a *app) proxy(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("proxy", flag.ContinueOnError)
+	addr := flags.String("addr", "127.0.0.1:2222", "Local `address` to listen on.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if err := a.initClient(ctx); err != nil {
+		return err
+	}
+	if err := a.start(ctx); err != nil {
+		return err
+	}
+	e, err := a.getEnvironment(ctx)
+	if err != nil {
+		return err
+	}
+	upstream, err := a.dialSSH(e)
+	if err != nil {
+		return err
+	}
+	defer upstream.Close()
+
+	signer, err := a.hostKeySigner()
+	if err != nil {
+		return fmt.Errorf("could not set up host key: %w", err)
+	}
+	authorized, err := a.authorizedKeysCallback()
+	if err != nil {
+		return err
+	}
+
+	config := &ssh.ServerConfig{PublicKeyCallback: authorized}
+	config.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return fmt.Errorf("could not listen on %s: %w", *addr, err)
+	}
+	defer l.Close()
+	a.logf("Proxy listening on %s. Fingerprint: %s", *addr, ssh.FingerprintSHA256(signer.PublicKey()))
+
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go a.serveProxyConn(conn, config, upstream)
+	}
+}
+
+// hostKeySigner loads the proxy's host key from the state directory,
+// generating and persisting a new Ed25519 key pair the first time the proxy
+// runs so the host key stays stable across restarts.
+func (a *app) hostKeySigner() (ssh.Signer, error) {
+	path := filepath.Join(a.stateDir, "host_key")
+
+	if b, err := os.ReadFile(path); err == nil {
+		return ssh.ParsePrivateKey(b)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.NewSignerFromSigner(priv)
+	if err != nil {
+		return nil, err
+	}
+	marshaled, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(marshaled), 0o600); err != nil {
+		return nil, err
+	}
+	return signer, nil
+}
+
+// authorizedKeysCallback builds a [ssh.PublicKeyCallback] that accepts
+// exactly the keys listed (one per line, OpenSSH authorized_keys format) in
+// stateDir/authorized_keys.
+func (a *app) authorizedKeysCallback() (func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error), error) {
+	path := filepath.Join(a.stateDir, "authorized_keys")
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("no authorized keys configured, add at least one public key to %s", path)
+		}
+		return nil, err
+	}
+
+	allowed := make(map[string]bool)
+	for len(b) > 0 {
+		pk, _, _, rest, err := ssh.ParseAuthorizedKey(b)
+		if err != nil {
+			break
+		}
+		allowed[string(pk.Marshal())] = true
+		b = rest
+	}
+	if len(allowed) == 0 {
+		return nil, fmt.Errorf("%s contains no valid authorized keys", path)
+	}
+
+	return func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+		if !allowed[string(key.Marshal())] {
+			return nil, fmt.Errorf("unknown public key from %s", conn.RemoteAddr())
+		}
+		return nil, nil
+	}, nil
+}
+
+// serveProxyConn handshakes an incoming connection as an SSH server and
+// forwards every channel it opens to the upstream Cloud Shell environment.
+func (a *app) serveProxyConn(conn net.Conn, config *ssh.ServerConfig, upstream *ssh.Client) {
+	sc, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		a.logf("proxy: handshake failed: %v", err)
+		return
+	}
+	defer sc.Close()
+
+	go ssh.DiscardRequests(reqs)
+
+	for newChan := range chans {
+		switch newChan.ChannelType() {
+		case "session":
+			go a.forwardSession(newChan, upstream)
+		case "direct-tcpip":
+			go a.forwardDirectTCPIP(newChan, upstream)
+		default:
+			newChan.Reject(ssh.UnknownChannelType, "unsupported channel type")
+		}
+	}
+}
+
+// forwardSession forwards an incoming "session" channel (shell, exec or
+// subsystem, e.g. sftp) to a freshly created session on the upstream client.
+func (a *app) forwardSession(newChan ssh.NewChannel, upstream *ssh.Client) {
+	ch, reqs, err := newChan.Accept()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+
+	upstreamSession, err := upstream.NewSession()
+	if err != nil {
+		a.logf("proxy: could not open upstream session: %v", err)
+		return
+	}
+	defer upstreamSession.Close()
+
+	upstreamSession.Stdout = ch
+	upstreamSession.Stderr = ch.Stderr()
+	upstreamSession.Stdin = ch
+
+	waitAndClose := func() {
+		upstreamSession.Wait()
+		ch.Close()
+	}
+
+	for req := range reqs {
+		ok := true
+		switch req.Type {
+		case "pty-req":
+			term, w, h, parsed := parsePtyReq(req.Payload)
+			ok = parsed && upstreamSession.RequestPty(term, h, w, ssh.TerminalModes{}) == nil
+		case "window-change":
+			if w, h, parsed := parseWindowChange(req.Payload); parsed {
+				upstreamSession.WindowChange(h, w)
+			}
+		case "shell":
+			ok = upstreamSession.Shell() == nil
+			if ok {
+				go waitAndClose()
+			}
+		case "exec":
+			cmd, parsed := parseCommandReq(req.Payload)
+			ok = parsed && upstreamSession.Start(cmd) == nil
+			if ok {
+				go waitAndClose()
+			}
+		case "subsystem":
+			name, parsed := parseCommandReq(req.Payload)
+			ok = parsed && upstreamSession.RequestSubsystem(name) == nil
+			if ok {
+				go waitAndClose()
+			}
+		case "env":
+			// Environment variables aren't forwarded; acknowledge and ignore.
+		default:
+			ok = false
+		}
+		if req.WantReply {
+			req.Reply(ok, nil)
+		}
+	}
+}
+
+// forwardDirectTCPIP forwards an incoming "direct-tcpip" channel (a port
+// forward originated by the connecting SSH client) to the corresponding
+// address inside the upstream Cloud Shell environment.
+func (a *app) forwardDirectTCPIP(newChan ssh.NewChannel, upstream *ssh.Client) {
+	var payload struct {
+		DestAddr string
+		DestPort uint32
+		SrcAddr  string
+		SrcPort  uint32
+	}
+	if err := ssh.Unmarshal(newChan.ExtraData(), &payload); err != nil {
+		newChan.Reject(ssh.ConnectionFailed, "malformed direct-tcpip request")
+		return
+	}
+
+	remoteAddr := net.JoinHostPort(payload.DestAddr, fmt.Sprintf("%d", payload.DestPort))
+	remote, err := upstream.Dial("tcp", remoteAddr)
+	if err != nil {
+		newChan.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	defer remote.Close()
+
+	ch, reqs, err := newChan.Accept()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+	go ssh.DiscardRequests(reqs)
+
+	done := make(chan struct{}, 2)
+	go func() { io.Copy(remote, ch); done <- struct{}{} }()
+	go func() { io.Copy(ch, remote); done <- struct{}{} }()
+	<-done
+}
+
+// parsePtyReq decodes the payload of a "pty-req" channel request.
+func parsePtyReq(payload []byte) (term string, width, height int, ok bool) {
+	r := bytes.NewReader(payload)
+	termBuf, ok := readLengthPrefixed(r)
+	if !ok {
+		return "", 0, 0, false
+	}
+	var w, h, _, _ uint32
+	for _, v := range []*uint32{&w, &h, new(uint32), new(uint32)} {
+		if err := readUint32(r, v); err != nil {
+			return "", 0, 0, false
+		}
+	}
+	return string(termBuf), int(w), int(h), true
+}
+
+// parseWindowChange decodes the payload of a "window-change" channel
+// request.
+func parseWindowChange(payload []byte) (width, height int, ok bool) {
+	r := bytes.NewReader(payload)
+	var w, h uint32
+	if err := readUint32(r, &w); err != nil {
+		return 0, 0, false
+	}
+	if err := readUint32(r, &h); err != nil {
+		return 0, 0, false
+	}
+	return int(w), int(h), true
+}
+
+// parseCommandReq decodes the payload of an "exec" or "subsystem" channel
+// request, both of which consist of a single length-prefixed string (the
+// command line or subsystem name).
+func parseCommandReq(payload []byte) (s string, ok bool) {
+	r := bytes.NewReader(payload)
+	buf, ok := readLengthPrefixed(r)
+	if !ok {
+		return "", false
+	}
+	return string(buf), true
+}
+
+func readUint32(r io.Reader, v *uint32) error {
+	var buf [4]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return err
+	}
+	*v = uint32(buf[0])<<24 | uint32(buf[1])<<16 | uint32(buf[2])<<8 | uint32(buf[3])
+	return nil
+}
+
+// readLengthPrefixed reads a uint32-length-prefixed byte string, as used
+// throughout the SSH connection protocol. The declared length is checked
+// against the remaining bytes in r before allocating, so a bogus, oversized
+// length can't be used to force a multi-gigabyte allocation.
+func readLengthPrefixed(r *bytes.Reader) (b []byte, ok bool) {
+	var n uint32
+	if err := readUint32(r, &n); err != nil {
+		return nil, false
+	}
+	if int64(n) > int64(r.Len()) {
+		return nil, false
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, false
+	}
+	return buf, true
+}
+
+// sshConfig implements the "ssh-config" command: it prints a ready-to-use
+// OpenSSH config block pointing at the proxy, for inclusion in ~/.ssh/config.
+func (a *app) sshConfig(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("ssh-config", flag.ContinueOnError)
+	addr := flags.String("addr", "127.0.0.1:2222", "Local `address` the proxy listens on.")
+	host := flags.String("host", "cloudshell", "`Host` alias to use in the config block.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	h, port, err := net.SplitHostPort(*addr)
+	if err != nil {
+		return fmt.Errorf("invalid -addr %q: %w", *addr, err)
+	}
+	if err := a.setupStateDir(ctx); err != nil {
+		return err
+	}
+
+	signer, err := a.hostKeySigner()
+	if err != nil {
+		return fmt.Errorf("could not set up host key: %w", err)
+	}
+	knownHostsPath, err := a.writeProxyKnownHosts(*addr, signer.PublicKey())
+	if err != nil {
+		return fmt.Errorf("could not write %s: %w", filepath.Join(a.stateDir, "proxy_known_hosts"), err)
+	}
+
+	env := cli.GetEnv(ctx)
+	fmt.Fprintf(env.Stdout, "Host %s\n", *host)
+	fmt.Fprintf(env.Stdout, "    HostName %s\n", h)
+	fmt.Fprintf(env.Stdout, "    Port %s\n", port)
+	fmt.Fprintf(env.Stdout, "    UserKnownHostsFile %s\n", knownHostsPath)
+	return nil
+}
+
+// writeProxyKnownHosts (re)writes stateDir/proxy_known_hosts with a single
+// known_hosts entry pinning the proxy's own stable host key for addr, so
+// that an OpenSSH client pointed at the printed config can verify it instead
+// of silently trusting whatever it's first presented with.
+func (a *app) writeProxyKnownHosts(addr string, pub ssh.PublicKey) (string, error) {
+	path := filepath.Join(a.stateDir, "proxy_known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, pub)
+	if err := os.WriteFile(path, []byte(line+"\n"), 0o600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,75 @@
+// © 2025 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the ISC
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import "testing"
+
+// sshString encodes s as an SSH protocol length-prefixed string.
+func sshString(s string) []byte {
+	n := len(s)
+	return append([]byte{byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}, s...)
+}
+
+func sshUint32(v uint32) []byte {
+	return []byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}
+}
+
+func TestParsePtyReq(t *testing.T) {
+	payload := append(sshString("xterm-256color"), append(sshUint32(80), sshUint32(24)...)...)
+	payload = append(payload, sshUint32(0)...) // width in pixels
+	payload = append(payload, sshUint32(0)...) // height in pixels
+
+	term, w, h, ok := parsePtyReq(payload)
+	if !ok {
+		t.Fatalf("parsePtyReq(): parsing failed, want success")
+	}
+	if term != "xterm-256color" || w != 80 || h != 24 {
+		t.Errorf("parsePtyReq() = (%q, %d, %d), want (%q, 80, 24)", term, w, h, "xterm-256color")
+	}
+
+	if _, _, _, ok := parsePtyReq(sshString("xterm")[:3]); ok {
+		t.Errorf("parsePtyReq(truncated): got success, want failure")
+	}
+}
+
+func TestParsePtyReqOversizedLength(t *testing.T) {
+	// A declared length far larger than what actually follows must be
+	// rejected rather than allocated.
+	payload := sshUint32(1 << 30)
+	if _, _, _, ok := parsePtyReq(payload); ok {
+		t.Errorf("parsePtyReq(oversized length): got success, want failure")
+	}
+}
+
+func TestParseWindowChange(t *testing.T) {
+	payload := append(sshUint32(100), sshUint32(40)...)
+	w, h, ok := parseWindowChange(payload)
+	if !ok || w != 100 || h != 40 {
+		t.Errorf("parseWindowChange() = (%d, %d, %v), want (100, 40, true)", w, h, ok)
+	}
+
+	if _, _, ok := parseWindowChange(payload[:2]); ok {
+		t.Errorf("parseWindowChange(truncated): got success, want failure")
+	}
+}
+
+func TestParseCommandReq(t *testing.T) {
+	payload := sshString("sftp")
+	cmd, ok := parseCommandReq(payload)
+	if !ok || cmd != "sftp" {
+		t.Errorf("parseCommandReq() = (%q, %v), want (%q, true)", cmd, ok, "sftp")
+	}
+
+	if _, ok := parseCommandReq(payload[:2]); ok {
+		t.Errorf("parseCommandReq(truncated): got success, want failure")
+	}
+}
+
+func TestParseCommandReqOversizedLength(t *testing.T) {
+	payload := sshUint32(1 << 30)
+	if _, ok := parseCommandReq(payload); ok {
+		t.Errorf("parseCommandReq(oversized length): got success, want failure")
+	}
+}
@@ -0,0 +1,113 @@
+// © 2025 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the ISC
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// sessionRecorder records an interactive SSH session as it plays out, in two
+// formats: an asciicast v2 file (playable with "asciinema play") and a
+// classic script(1) "typescript" sidecar alongside it. It's safe for
+// concurrent use, since output and resize events can both be written from
+// the goroutine handling SIGWINCH.
+type sessionRecorder struct {
+	mu         sync.Mutex
+	cast       *os.File
+	typescript *os.File
+	start      time.Time
+}
+
+// newSessionRecorder creates the recording at path (an asciicast v2 file)
+// and path+".typescript" (a plain script(1)-style sidecar), writing the
+// asciicast header that describes the session's initial terminal size and
+// where it connects to.
+func newSessionRecorder(path string, width, height int, e environment) (*sessionRecorder, error) {
+	cast, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	typescript, err := os.OpenFile(path+".typescript", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		cast.Close()
+		return nil, err
+	}
+
+	header := struct {
+		Version int               `json:"version"`
+		Width   int               `json:"width"`
+		Height  int               `json:"height"`
+		Time    int64             `json:"timestamp"`
+		Title   string            `json:"title"`
+		Env     map[string]string `json:"env"`
+	}{
+		Version: 2,
+		Width:   width,
+		Height:  height,
+		Time:    time.Now().Unix(),
+		Title:   fmt.Sprintf("%s@%s (%s)", e.SSHUsername, e.SSHHost, e.DockerImage),
+		Env:     map[string]string{"TERM": "xterm-256color"},
+	}
+	b, err := json.Marshal(header)
+	if err != nil {
+		cast.Close()
+		typescript.Close()
+		return nil, err
+	}
+	if _, err := cast.Write(append(b, '\n')); err != nil {
+		cast.Close()
+		typescript.Close()
+		return nil, err
+	}
+
+	fmt.Fprintf(typescript, "Script started on %s\n", time.Now().Format(time.UnixDate))
+
+	return &sessionRecorder{cast: cast, typescript: typescript, start: time.Now()}, nil
+}
+
+// Write implements [io.Writer], recording p as an asciicast "o" (output)
+// event and appending it to the typescript sidecar.
+func (r *sessionRecorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.writeEvent("o", string(p))
+	r.typescript.Write(p)
+	return len(p), nil
+}
+
+// Resize records a terminal resize as an asciicast "r" event, in the
+// "<width>x<height>" format asciinema players expect.
+func (r *sessionRecorder) Resize(width, height int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.writeEvent("r", fmt.Sprintf("%dx%d", width, height))
+}
+
+// writeEvent appends a single asciicast event line. r.mu must be held.
+func (r *sessionRecorder) writeEvent(typ, data string) {
+	event := [3]any{time.Since(r.start).Seconds(), typ, data}
+	b, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	r.cast.Write(append(b, '\n'))
+}
+
+// Close finalizes both recording files.
+func (r *sessionRecorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fmt.Fprintf(r.typescript, "\nScript done on %s\n", time.Now().Format(time.UnixDate))
+	castErr := r.cast.Close()
+	tsErr := r.typescript.Close()
+	if castErr != nil {
+		return castErr
+	}
+	return tsErr
+}
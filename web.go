@@ -0,0 +1,78 @@
+// © 2025 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the ISC
+// license that can be found in the LICENSE.md file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"strconv"
+
+	"go.astrophena.name/base/cli"
+)
+
+// web implements the "web" command: it gives one-shot access to a web
+// preview of a server running inside the Cloud Shell environment, either by
+// opening its public web-preview URL in the browser or, with -local, by
+// forwarding a local address to it over SSH.
+func (a *app) web(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("web", flag.ContinueOnError)
+	port := flags.Int("port", 8080, "Remote `port` inside the environment to preview.")
+	local := flags.String("local", "", "Local `address` to forward to the preview instead of opening a browser, e.g. 127.0.0.1:8080.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if err := a.initClient(ctx); err != nil {
+		return err
+	}
+	if err := a.start(ctx); err != nil {
+		return err
+	}
+	e, err := a.getEnvironment(ctx)
+	if err != nil {
+		return err
+	}
+	if e.WebHost == "" {
+		return errors.New("web preview is unavailable for this environment")
+	}
+
+	if *local == "" {
+		url := webPreviewURL(e.WebHost, *port)
+		if !openBrowser(url) {
+			env := cli.GetEnv(ctx)
+			fmt.Fprintf(env.Stderr, "Go to the following link in your browser: %s\n", url)
+		}
+		return nil
+	}
+
+	client, err := a.dialSSH(e)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	l, err := net.Listen("tcp", *local)
+	if err != nil {
+		return fmt.Errorf("could not listen on %s: %w", *local, err)
+	}
+	defer l.Close()
+
+	targetAddr := net.JoinHostPort("localhost", strconv.Itoa(*port))
+	a.logf("Forwarding %s -> %s inside the environment", *local, targetAddr)
+	go acceptForwards(l, client.Dial, targetAddr, a.logf)
+
+	<-ctx.Done()
+	return nil
+}
+
+// webPreviewURL builds the public web-preview URL for port on an environment
+// whose WebHost is webHost, following the same "<port>-<webHost>" scheme as
+// the Cloud Shell web UI and "gcloud cloud-shell ssh --authorize-session".
+func webPreviewURL(webHost string, port int) string {
+	return fmt.Sprintf("https://%d-%s", port, webHost)
+}